@@ -19,15 +19,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -46,35 +51,128 @@ type patternMatcher struct {
 
 // languages is a map of the markers used to denote comments in each language.
 var languages = map[string]commentMarkers{
-	".bazel": {"#", "", ""},
-	".c":     {"//", "/*", "*/"},
-	".cpp":   {"//", "/*", "*/"},
-	".cs":    {"//", "/*", "*/"},
-	".css":   {"", "/*", "*/"},
-	".go":    {"//", "/*", "*/"},
-	".html":  {"", "<!--", "-->"},
-	".java":  {"//", "/*", "*/"},
-	".js":    {"//", "/*", "*/"},
-	".php":   {"//", "/*", "*/"},
-	".ps1":   {"#", "<#", "#>"},
-	".py":    {"#", "", ""},
-	".sh":    {"#", "", ""},
-	".sql":   {"--", "", ""},
-	".tf":    {"#", "/*", "*/"},
-	".ts":    {"//", "/*", "*/"},
-	".xml":   {"", "<!--", "-->"},
-	".yaml":  {"#", "", ""},
-	".yml":   {"#", "", ""},
+	".bazel":      {"#", "", ""},
+	".c":          {"//", "/*", "*/"},
+	".cpp":        {"//", "/*", "*/"},
+	".cs":         {"//", "/*", "*/"},
+	".css":        {"", "/*", "*/"},
+	".dockerfile": {"#", "", ""},
+	".go":         {"//", "/*", "*/"},
+	".hcl":        {"#", "/*", "*/"},
+	".html":       {"", "<!--", "-->"},
+	".ini":        {";", "", ""},
+	".java":       {"//", "/*", "*/"},
+	".js":         {"//", "/*", "*/"},
+	".kt":         {"//", "/*", "*/"},
+	".lua":        {"--", "--[[", "]]"},
+	".php":        {"//", "/*", "*/"},
+	".proto":      {"//", "/*", "*/"},
+	".ps1":        {"#", "<#", "#>"},
+	".py":         {"#", "", ""},
+	".r":          {"#", "", ""},
+	".rb":         {"#", "", ""},
+	".rs":         {"//", "/*", "*/"},
+	".scala":      {"//", "/*", "*/"},
+	".sh":         {"#", "", ""},
+	".sql":        {"--", "", ""},
+	".swift":      {"//", "/*", "*/"},
+	".tf":         {"#", "/*", "*/"},
+	".toml":       {"#", "", ""},
+	".ts":         {"//", "/*", "*/"},
+	".xml":        {"", "<!--", "-->"},
+	".yaml":       {"#", "", ""},
+	".yml":        {"#", "", ""},
+}
+
+// filenames maps exact file basenames that carry no extension to their comment markers.
+var filenames = map[string]commentMarkers{
+	"Dockerfile": {"#", "", ""},
+	"Makefile":   {"#", "", ""},
+}
+
+// interpreters maps shebang interpreters to comment markers, for files that carry no extension
+// (or an unrecognized one) but declare their interpreter on the first line.
+var interpreters = map[string]commentMarkers{
+	"bash":   {"#", "", ""},
+	"node":   {"//", "/*", "*/"},
+	"perl":   {"#", "", ""},
+	"python": {"#", "", ""},
+	"ruby":   {"#", "", ""},
+	"sh":     {"#", "", ""},
+	"zsh":    {"#", "", ""},
+}
+
+// languageFor determines the comment markers to use for fileName, consulting in order the
+// filenames table, the languages table keyed by extension, and finally, for extensionless or
+// unrecognized files, the interpreter named on the file's shebang line.
+func languageFor(fileName string) (lang commentMarkers, ok bool) {
+	base := filepath.Base(fileName)
+	if lang, ok := filenames[base]; ok {
+		return lang, true
+	}
+	if lang, ok := languages[filepath.Ext(base)]; ok {
+		return lang, true
+	}
+	return interpreterLanguage(fileName)
+}
+
+// shebangReadLimit bounds how many bytes interpreterLanguage reads from a candidate file: a
+// shebang line is conventionally well under this, and there's no need to read the whole file
+// just to sniff it.
+const shebangReadLimit = 512
+
+// interpreterLanguage inspects the shebang line of fileName, if any, and maps its interpreter
+// to a set of comment markers via the interpreters table.
+func interpreterLanguage(fileName string) (lang commentMarkers, ok bool) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return commentMarkers{}, false
+	}
+	defer f.Close()
+	buf := make([]byte, shebangReadLimit)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return commentMarkers{}, false
+	}
+	firstLine := string(buf[:n])
+	if i := strings.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+	firstLine = strings.TrimPrefix(firstLine, "\uFEFF")
+	firstLine = strings.TrimSuffix(firstLine, "\r")
+	if !strings.HasPrefix(firstLine, "#!") {
+		return commentMarkers{}, false
+	}
+	fields := strings.Fields(firstLine[2:])
+	if len(fields) == 0 {
+		return commentMarkers{}, false
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	lang, ok = interpreters[interpreter]
+	return lang, ok
 }
 
 var (
-	flagVerbose bool
+	flagVerbose     bool
+	flagCheck       bool
+	flagJobs        int
+	flagNoGitignore bool
+	flagHolder      string
+	flagSPDX        string
 )
 
 // main runs a code generator that injects a copyright notice to source files.
 func main() {
 	// Parse CLI flags
 	flag.BoolVar(&flagVerbose, "v", false, "Verbose")
+	flag.BoolVar(&flagCheck, "check", false, "Check that copyright notices are up to date without writing changes")
+	flag.IntVar(&flagJobs, "j", runtime.NumCPU(), "Number of files to process in parallel")
+	flag.BoolVar(&flagNoGitignore, "no-gitignore", false, "Don't skip files that are excluded by .gitignore")
+	flag.StringVar(&flagHolder, "holder", "", "Copyright holder, available in the notice template as {{.Holder}}")
+	flag.StringVar(&flagSPDX, "spdx", "", "SPDX-License-Identifier, available in the notice template as {{.SPDX}}")
 	flag.Parse()
 
 	err := mainErr()
@@ -92,11 +190,20 @@ func mainErr() error {
 		return fmt.Errorf("unable to read copyright.go: %w", err)
 	}
 	source := string(b)
-	notice, ok, _, _ := firstComment(source, languages[".go"])
+	raw, ok, _, _ := firstComment(source, languages[".go"])
 	if !ok {
 		return fmt.Errorf("no comment found in copyright.go")
 	}
-	notice = strings.ReplaceAll(notice, "YYYY", strconv.Itoa(time.Now().Year()))
+	tmpl, err := template.New("notice").Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid notice template in copyright.go: %w", err)
+	}
+	np := &noticeParams{
+		tmpl:   tmpl,
+		year:   time.Now().Year(),
+		holder: flagHolder,
+		spdx:   flagSPDX,
+	}
 
 	// Parse the file matching patterns
 	patterns := []patternMatcher{}
@@ -135,28 +242,467 @@ func mainErr() error {
 		})
 	}
 
+	// In check mode, report on the state of the files without writing any changes
+	if flagCheck {
+		var reports []checkReport
+		err = checkDir(".", np, patterns, nil, &reports)
+		if err != nil {
+			return err
+		}
+		for _, r := range reports {
+			fmt.Printf("%-64s %s\n", r.File, r.Status)
+		}
+		if len(reports) > 0 {
+			return fmt.Errorf("%d file(s) with missing or outdated copyright notices", len(reports))
+		}
+		return nil
+	}
+
 	// Apply the comment to the files in all subdirectories
-	err = processDir(".", notice, patterns)
+	err = processDir(".", np, patterns)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// processDir applies the copyright notice to the source files in the indicated directory.
-func processDir(dirPath string, notice string, patterns []patternMatcher) error {
+// fileTask is a single file queued for copyrighting by the processDir worker pool.
+type fileTask struct {
+	path string
+	lang commentMarkers
+}
+
+// noticeData is the set of variables available to the copyright notice template.
+type noticeData struct {
+	Year      int    // The current year
+	StartYear int    // The year the file was added to git history, or Year if that can't be determined
+	File      string // The path of the file being copyrighted
+	Holder    string // The copyright holder, from the -holder flag
+	SPDX      string // The SPDX-License-Identifier, from the -spdx flag
+}
+
+// noticeParams holds the inputs needed to render the copyright notice template for any file.
+type noticeParams struct {
+	tmpl   *template.Template
+	year   int
+	holder string
+	spdx   string
+}
+
+// dataFor builds the template data to render the notice for the named file.
+func (np *noticeParams) dataFor(fileName string) noticeData {
+	return noticeData{
+		Year:      np.year,
+		StartYear: startYear(fileName, np.year),
+		File:      fileName,
+		Holder:    np.holder,
+		SPDX:      np.spdx,
+	}
+}
+
+var (
+	startYearsOnce sync.Once
+	startYearAdds  map[string]int
+	startYearFrom  map[string]string
+)
+
+// startYearLogArgs are the `git log` arguments that produce the tagged, name-status history
+// parseStartYearLog expects: one "\x01<year>" marker per commit followed by its "A" (added) and
+// "R" (renamed) records, oldest first.
+var startYearLogArgs = []string{"log", "--reverse", "-M", "--name-status", "--diff-filter=AR", "--date=format:%Y", "--format=\x01%ad"}
+
+// parseStartYearLog parses the output of a `git log` invocation with startYearLogArgs into
+// addYears, from every path that was ever added to the year of that "added" commit, and
+// renameFrom, from every renamed-to path to the path it was renamed from. Separating this
+// parsing from loadStartYears keeps it testable without shelling out to git.
+func parseStartYearLog(out string) (addYears map[string]int, renameFrom map[string]string) {
+	addYears = map[string]int{}
+	renameFrom = map[string]string{}
+	year := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "\x01") {
+			if y, err := strconv.Atoi(strings.TrimPrefix(line, "\x01")); err == nil {
+				year = y
+			}
+			continue
+		}
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		switch {
+		case strings.HasPrefix(fields[0], "A") && len(fields) == 2:
+			if _, exists := addYears[fields[1]]; !exists {
+				addYears[fields[1]] = year
+			}
+		case strings.HasPrefix(fields[0], "R") && len(fields) == 3:
+			renameFrom[fields[2]] = fields[1]
+		}
+	}
+	return addYears, renameFrom
+}
+
+// resolveStartYear walks fileName back through renameFrom to find the path it originated as,
+// and returns the year that path was added, or fallback if fileName (or any of its former paths)
+// was never recorded as added.
+func resolveStartYear(addYears map[string]int, renameFrom map[string]string, fileName string, fallback int) int {
+	if len(addYears) == 0 {
+		return fallback
+	}
+	path := strings.TrimPrefix(filepath.ToSlash(fileName), "./")
+	for seen := map[string]bool{}; path != "" && !seen[path]; {
+		seen[path] = true
+		if y, ok := addYears[path]; ok {
+			return y
+		}
+		path = renameFrom[path]
+	}
+	return fallback
+}
+
+// loadStartYears runs a single `git log` pass over the whole repository history and caches the
+// resulting addYears/renameFrom maps. Computing this once for the whole repository avoids
+// shelling out to git (with the expensive --follow mode) once per file, which would otherwise
+// undercut the parallel walk's wall-clock win, while the rename map lets startYear walk back
+// through a file's renames to find its true origin.
+func loadStartYears() {
+	startYearsOnce.Do(func() {
+		startYearAdds = map[string]int{}
+		startYearFrom = map[string]string{}
+		out, err := exec.Command("git", startYearLogArgs...).Output()
+		if err != nil {
+			return
+		}
+		startYearAdds, startYearFrom = parseStartYearLog(string(out))
+	})
+}
+
+// startYear returns the year the named file was first added to git history, following back
+// through any renames to find the file's true origin, or fallback if the file is new (not yet
+// committed), untracked, or git history is unavailable.
+func startYear(fileName string, fallback int) int {
+	loadStartYears()
+	return resolveStartYear(startYearAdds, startYearFrom, fileName, fallback)
+}
+
+// gitignoreRule is a single compiled pattern from a .gitignore file.
+type gitignoreRule struct {
+	negate  bool
+	dirOnly bool
+	exp     *regexp.Regexp
+}
+
+// gitignoreBase returns the slash-separated path of dirPath relative to the scan root, treating
+// the root itself (".") as the empty base.
+func gitignoreBase(dirPath string) string {
+	if dirPath == "." {
+		return ""
+	}
+	return filepath.ToSlash(dirPath)
+}
+
+// compileGitignoreLine compiles a single line of a .gitignore file into a rule anchored at base,
+// the slash-separated directory the .gitignore file lives in. It returns ok=false for blank lines
+// and comments.
+func compileGitignoreLine(base string, line string) (rule gitignoreRule, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	line = strings.TrimRight(line, " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return gitignoreRule{}, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if base != "" {
+		sb.WriteString(regexp.QuoteMeta("/" + base))
+	}
+	if anchored {
+		sb.WriteString("/")
+	} else {
+		sb.WriteString("/(?:.*/)?")
+	}
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '*':
+			sb.WriteString(".*")
+			i++
+		case runes[i] == '*':
+			sb.WriteString("[^/]*")
+		case runes[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	sb.WriteString("(?:/.*)?$")
+	exp, err := regexp.Compile(sb.String())
+	if err != nil {
+		return gitignoreRule{}, false
+	}
+	rule.exp = exp
+	return rule, true
+}
+
+// loadGitignore reads and compiles the .gitignore file in dirPath, if any.
+func loadGitignore(dirPath string) []gitignoreRule {
+	b, err := os.ReadFile(filepath.Join(dirPath, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	base := gitignoreBase(dirPath)
+	var rules []gitignoreRule
+	for _, line := range strings.Split(string(b), "\n") {
+		if rule, ok := compileGitignoreLine(base, line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// gitignoreMatch reports whether path (relative to the scan root) is ignored by rules, which
+// holds the cumulative, depth-ordered rules of path's directory and all of its ancestors.
+// The last matching rule wins, mirroring git's own semantics.
+func gitignoreMatch(rules []gitignoreRule, path string, isDir bool) bool {
+	ignored := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.exp.MatchString("/" + filepath.ToSlash(path)) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// isDotDir reports whether name is a VCS or other dot directory (".git", ".svn", ".hg", etc.)
+// that must never be descended into, regardless of patterns or .gitignore rules: these
+// directories are never under version control themselves and commonly hold template files
+// (e.g. .git/hooks/*.sample) that look like source but must never be touched.
+func isDotDir(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// processDir walks the directory tree rooted at dirPath and applies the copyright notice to its
+// source files, using a bounded pool of workers (sized by the -j flag) to process files in
+// parallel. The first error encountered cancels the remainder of the walk.
+func processDir(dirPath string, np *noticeParams, patterns []patternMatcher) error {
+	jobs := flagJobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tasks := make(chan fileTask, jobs)
+	gitignoreRules := map[string][]gitignoreRule{}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			for t := range tasks {
+				buf.Reset()
+				if err := processFile(t.path, t.lang, np, &buf); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	walkErr := filepath.WalkDir(dirPath, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fs.SkipAll
+		default:
+		}
+		if de.IsDir() {
+			// Never descend into .git or other VCS/dot directories, regardless of patterns
+			if path != dirPath && isDotDir(de.Name()) {
+				if flagVerbose {
+					fmt.Println(path + " (skipped)")
+				}
+				return fs.SkipDir
+			}
+			// Skip subdirectories that contain their own copyright.go file
+			if path != dirPath {
+				b, rerr := os.ReadFile(filepath.Join(path, "copyright.go"))
+				if rerr == nil && bytes.Contains(b, []byte("github.com/microbus-io/copyrighter")) {
+					if flagVerbose {
+						fmt.Println(path + " (skipped)")
+					}
+					return fs.SkipDir
+				}
+			}
+			// Apply the user-defined include/exclude patterns to the directory itself,
+			// mirroring the file matching below, so that a bare directory exclusion
+			// pattern (e.g. "// - /samples") still prunes the whole subtree.
+			if path != dirPath {
+				ignore := false
+				for _, p := range patterns {
+					if p.Exp.MatchString("/" + path) {
+						if p.Op == "-" {
+							ignore = true
+						}
+						if p.Op == "+" {
+							ignore = false
+						}
+					}
+				}
+				if ignore {
+					if flagVerbose {
+						fmt.Println(path + " (ignored)")
+					}
+					return fs.SkipDir
+				}
+			}
+			if !flagNoGitignore {
+				var parentRules []gitignoreRule
+				if path != dirPath {
+					parentRules = gitignoreRules[filepath.Dir(path)]
+				}
+				combined := append(append([]gitignoreRule{}, parentRules...), loadGitignore(path)...)
+				gitignoreRules[path] = combined
+				if path != dirPath && gitignoreMatch(combined, path, true) {
+					if flagVerbose {
+						fmt.Println(path + " (gitignored)")
+					}
+					return fs.SkipDir
+				}
+			}
+			if flagVerbose {
+				fmt.Println(path)
+			}
+			return nil
+		}
+		// Determine if to process
+		ignore := false
+		if !flagNoGitignore && gitignoreMatch(gitignoreRules[filepath.Dir(path)], path, false) {
+			ignore = true
+		}
+		for _, p := range patterns {
+			if p.Exp.MatchString("/" + path) {
+				if p.Op == "-" {
+					ignore = true
+				}
+				if p.Op == "+" {
+					ignore = false
+				}
+			}
+		}
+		if path == "copyright.go" {
+			ignore = true
+		}
+		if ignore {
+			if flagVerbose {
+				fmt.Printf("  %-32s (ignored)\n", path)
+			}
+			return nil
+		}
+		// Only process known languages
+		lang, ok := languageFor(path)
+		if !ok {
+			if flagVerbose {
+				fmt.Printf("  %-32s (disregarded)\n", path)
+			}
+			return nil
+		}
+		select {
+		case tasks <- fileTask{path: path, lang: lang}:
+		case <-ctx.Done():
+			return fs.SkipAll
+		}
+		return nil
+	})
+	close(tasks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	return nil
+}
+
+// processFile reads, processes and writes back a single file, reusing the caller-supplied buffer.
+func processFile(fileName string, lang commentMarkers, np *noticeParams, buf *bytes.Buffer) error {
+	source, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	ok, err := process(bytes.NewReader(source), buf, lang, np.tmpl, np.dataFor(fileName))
+	if err != nil {
+		return fmt.Errorf("failed to process '%s': %w", fileName, err)
+	}
+	if ok {
+		if flagVerbose {
+			fmt.Printf("  %-32s (copyrighted)\n", fileName)
+		}
+		err = os.WriteFile(fileName, buf.Bytes(), 0666)
+		if err != nil {
+			return fmt.Errorf("failed to write back '%s': %w", fileName, err)
+		}
+	} else {
+		if flagVerbose {
+			fmt.Printf("  %-32s (unchanged)\n", fileName)
+		}
+	}
+	return nil
+}
+
+// checkReport describes the state of a single file's copyright notice.
+type checkReport struct {
+	File   string
+	Status string
+}
+
+// checkDir scans the source files in the indicated directory and reports on the state of their
+// copyright notices without writing any changes. It mirrors the file selection logic of
+// processDir, including its .gitignore awareness, so --check never flags files the normal run
+// would never touch. gitignoreRules holds the cumulative, depth-ordered rules inherited from
+// dirPath's ancestors.
+func checkDir(dirPath string, np *noticeParams, patterns []patternMatcher, gitignoreRules []gitignoreRule, reports *[]checkReport) error {
 	// Skip subdirectories that contain their own copyright.go file
 	if dirPath != "." {
 		b, err := os.ReadFile(filepath.Join(dirPath, "copyright.go"))
 		if err == nil && bytes.Contains(b, []byte("github.com/microbus-io/copyrighter")) {
-			if flagVerbose {
-				fmt.Println(dirPath + " (skipped)")
-			}
 			return nil
 		}
 	}
-	if flagVerbose {
-		fmt.Println(dirPath)
+	if !flagNoGitignore {
+		gitignoreRules = append(append([]gitignoreRule{}, gitignoreRules...), loadGitignore(dirPath)...)
 	}
 	// Iterate over files
 	dirEntries, err := os.ReadDir(dirPath)
@@ -165,9 +711,16 @@ func processDir(dirPath string, notice string, patterns []patternMatcher) error
 	}
 	subDirs := []fs.DirEntry{}
 	for _, de := range dirEntries {
+		// Never descend into .git or other VCS/dot directories
+		if de.IsDir() && isDotDir(de.Name()) {
+			continue
+		}
 		fileName := filepath.Join(dirPath, de.Name())
 		// Determine if to process
 		ignore := false
+		if !flagNoGitignore && gitignoreMatch(gitignoreRules, fileName, de.IsDir()) {
+			ignore = true
+		}
 		for _, p := range patterns {
 			if p.Exp.MatchString("/" + fileName) {
 				if p.Op == "-" {
@@ -182,9 +735,6 @@ func processDir(dirPath string, notice string, patterns []patternMatcher) error
 			ignore = true
 		}
 		if ignore {
-			if flagVerbose {
-				fmt.Printf("  %-32s (ignored)\n", de.Name())
-			}
 			continue
 		}
 		// Collect sub directories
@@ -193,40 +743,25 @@ func processDir(dirPath string, notice string, patterns []patternMatcher) error
 			continue
 		}
 		// Only process known languages
-		ext := filepath.Ext(de.Name())
-		lang, ok := languages[ext]
+		lang, ok := languageFor(fileName)
 		if !ok {
-			if flagVerbose {
-				fmt.Printf("  %-32s (disregarded)\n", de.Name())
-			}
 			continue
 		}
 		source, err := os.ReadFile(fileName)
 		if err != nil {
 			return err
 		}
-		var toWrite bytes.Buffer
-		ok, err = process(bytes.NewReader(source), &toWrite, lang, notice)
+		status, err := Check(bytes.NewReader(source), lang, np.tmpl, np.dataFor(fileName))
 		if err != nil {
-			return fmt.Errorf("failed to process '%s': %w", fileName, err)
+			return fmt.Errorf("failed to check '%s': %w", fileName, err)
 		}
-		if ok {
-			if flagVerbose {
-				fmt.Printf("  %-32s (copyrighted)\n", de.Name())
-			}
-			err = os.WriteFile(fileName, toWrite.Bytes(), 0666)
-			if err != nil {
-				return fmt.Errorf("failed to write back '%s': %w", fileName, err)
-			}
-		} else {
-			if flagVerbose {
-				fmt.Printf("  %-32s (unchanged)\n", de.Name())
-			}
+		if status != "" {
+			*reports = append(*reports, checkReport{File: fileName, Status: status})
 		}
 	}
 	// Recurse into sub directories
 	for _, de := range subDirs {
-		err = processDir(filepath.Join(dirPath, de.Name()), notice, patterns)
+		err = checkDir(filepath.Join(dirPath, de.Name()), np, patterns, gitignoreRules, reports)
 		if err != nil {
 			return err
 		}
@@ -234,22 +769,91 @@ func processDir(dirPath string, notice string, patterns []patternMatcher) error
 	return nil
 }
 
-// process reads the source code from the reader, inserts the copyright notice if appropriate,
-// and writes the results to the writer.
-func process(r io.Reader, f io.Writer, lang commentMarkers, notice string) (ok bool, err error) {
+// Check reports on the state of the copyright notice in the source without modifying it.
+// It returns an empty status if the notice is up to date, or one of "missing", "outdated"
+// or "malformed" otherwise.
+func Check(r io.Reader, lang commentMarkers, tmpl *template.Template, data noticeData) (status string, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	var nb strings.Builder
+	if err := tmpl.Execute(&nb, data); err != nil {
+		return "", fmt.Errorf("failed to render notice template: %w", err)
+	}
+	notice := nb.String()
+	source := string(b)
+	comment, ok, _, _ := firstComment(source, lang)
+	if !ok {
+		return "missing", nil
+	}
+	if comment == notice {
+		return "", nil
+	}
+	if !strings.Contains(strings.ToLower(comment), "copyright") {
+		return "malformed", nil
+	}
+	return "outdated", nil
+}
+
+// xmlPrologPattern and doctypePattern match a leading XML processing instruction and DOCTYPE
+// declaration respectively, which must precede any comment in a well-formed XML or HTML document.
+var (
+	xmlPrologPattern = regexp.MustCompile(`^<\?xml[^>]*\?>\s*$`)
+	doctypePattern   = regexp.MustCompile(`(?i)^<!DOCTYPE[^>]*>\s*$`)
+)
+
+// preambleLines returns the number of leading lines that must be preserved ahead of any inserted
+// copyright notice: a `#!` shebang line (optionally preceded by a BOM), a `<?php` opener, or a
+// leading XML prolog and/or DOCTYPE declaration.
+func preambleLines(source string, lang commentMarkers) int {
+	lines := strings.Split(source, "\n")
+	if len(lines) == 0 {
+		return 0
+	}
+	lines[0] = strings.TrimPrefix(lines[0], "\uFEFF")
+	if lang.single == "#" && strings.HasPrefix(lines[0], "#!") {
+		return 1
+	}
+	if strings.HasPrefix(strings.TrimSpace(lines[0]), "<?php") {
+		return 1
+	}
+	if lang.multiBegin == "<!--" {
+		n := 0
+		if n < len(lines) && xmlPrologPattern.MatchString(strings.TrimSpace(lines[n])) {
+			n++
+		}
+		if n < len(lines) && doctypePattern.MatchString(strings.TrimSpace(lines[n])) {
+			n++
+		}
+		return n
+	}
+	return 0
+}
+
+// process reads the source code from the reader, renders the notice template with data, inserts
+// the result if appropriate, and writes the results to the writer.
+func process(r io.Reader, f io.Writer, lang commentMarkers, tmpl *template.Template, data noticeData) (ok bool, err error) {
 	b, err := io.ReadAll(r)
 	if err != nil {
 		return false, err
 	}
+	var nb strings.Builder
+	if err := tmpl.Execute(&nb, data); err != nil {
+		return false, fmt.Errorf("failed to render notice template: %w", err)
+	}
+	notice := nb.String()
 	source := string(b)
+	pre := preambleLines(source, lang)
 	firstComment, ok, fromLine, toLine := firstComment(source, lang)
 	if ok && firstComment == notice {
 		return false, nil
 	}
-	if ok && !strings.Contains(strings.ToLower(firstComment), "copyright") {
+	replacing := ok && strings.Contains(strings.ToLower(firstComment), "copyright")
+	if !replacing {
 		ok = false
-		fromLine = 0
-		toLine = 0
+		fromLine = pre
+		toLine = pre
 	}
 
 	lineSep := "\n"
@@ -280,7 +884,7 @@ func process(r io.Reader, f io.Writer, lang commentMarkers, notice string) (ok b
 	if err != nil {
 		return false, err
 	}
-	if fromLine == 0 && toLine == 0 && len(lines) > 0 && lines[0] != "" {
+	if fromLine == toLine && len(lines) > toLine && lines[toLine] != "" {
 		f.Write([]byte(lineSep))
 	}
 	// Insert lines after the copyright notice to be replaced
@@ -293,11 +897,14 @@ func process(r io.Reader, f io.Writer, lang commentMarkers, notice string) (ok b
 	return true, nil
 }
 
-// firstComment returns the first multi-line comment it finds.
+// firstComment returns the first multi-line comment it finds, skipping past a leading
+// shebang line or `<?php` opener so that it is never mistaken for a comment.
 func firstComment(source string, lang commentMarkers) (comment string, ok bool, fromLine int, toLine int) {
 	lines := strings.Split(source, "\n")
+	fromLine = preambleLines(source, lang)
+	toLine = fromLine
 	var inMulti, inSingle bool
-	for l := 0; l < len(lines); l++ {
+	for l := fromLine; l < len(lines); l++ {
 		line := lines[l]
 		trimmedLine := strings.TrimSpace(line)
 		switch {
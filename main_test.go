@@ -18,12 +18,19 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"os/exec"
 	"reflect"
 	"strings"
 	"testing"
+	"text/template"
 )
 
+func mustTemplate(text string) *template.Template {
+	return template.Must(template.New("notice").Parse(text))
+}
+
 func assertTrue(t *testing.T, b bool) bool {
 	if !b {
 		t.Fail()
@@ -185,16 +192,18 @@ package example
 }
 
 func Test_Main(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	_ = os.WriteFile("copyright.go", []byte("/*\nCopyright notice\n*/\n\n// - *.go\n\npackage main\n"), 0666)
 	_ = os.WriteFile("test.py", []byte(`print("hello")`), 0666)
-	defer os.Remove("test.py")
 	_ = os.Mkdir("testdir", os.ModePerm)
-	defer os.RemoveAll("testdir")
 	_ = os.WriteFile("testdir/test.cs", []byte(`namespace HelloWorld{}`), 0666)
-	defer os.Remove("testdir/test.cs")
 
-	flagExclude = "go"
-	flagRecurse = true
-	err := mainErr()
+	err = mainErr()
 	assertNoError(t, err)
 
 	f, err := os.ReadFile("test.py")
@@ -214,7 +223,7 @@ package example
 var x
 `
 	var sb strings.Builder
-	ok, err := process(strings.NewReader(source), &sb, languages[".go"], "Copyright notice")
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], mustTemplate("Copyright notice"), noticeData{})
 	if assertTrue(t, ok) && assertNoError(t, err) {
 		result := `/*
 Copyright notice
@@ -236,7 +245,7 @@ package example
 var x
 `
 	var sb strings.Builder
-	ok, err := process(strings.NewReader(source), &sb, languages[".go"], "Copyright notice")
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], mustTemplate("Copyright notice"), noticeData{})
 	if assertTrue(t, ok) && assertNoError(t, err) {
 		result := `/*
 Copyright notice
@@ -257,7 +266,7 @@ Old copyright notice
 var x
 `
 	var sb strings.Builder
-	ok, err := process(strings.NewReader(source), &sb, languages[".go"], "Copyright notice")
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], mustTemplate("Copyright notice"), noticeData{})
 	if assertTrue(t, ok) && assertNoError(t, err) {
 		result := `package example
 /*
@@ -272,7 +281,7 @@ var x
 func Test_Empty(t *testing.T) {
 	source := ``
 	var sb strings.Builder
-	ok, err := process(strings.NewReader(source), &sb, languages[".go"], "Copyright notice")
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], mustTemplate("Copyright notice"), noticeData{})
 	if assertTrue(t, ok) && assertNoError(t, err) {
 		result := `/*
 Copyright notice
@@ -287,7 +296,7 @@ func Test_CarriageReturn(t *testing.T) {
 		"\r\n" +
 		"var x\r\n"
 	var sb strings.Builder
-	ok, err := process(strings.NewReader(source), &sb, languages[".go"], "Copyright\nnotice")
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], mustTemplate("Copyright\nnotice"), noticeData{})
 	if assertTrue(t, ok) && assertNoError(t, err) {
 		result := "/*\r\n" +
 			"Copyright\r\nnotice\r\n" +
@@ -299,3 +308,375 @@ func Test_CarriageReturn(t *testing.T) {
 		assertEqual(t, result, sb.String())
 	}
 }
+
+func Test_Shebang(t *testing.T) {
+	source := `#!/usr/bin/env python
+print("hello")
+`
+	var sb strings.Builder
+	ok, err := process(strings.NewReader(source), &sb, languages[".py"], mustTemplate("Copyright notice"), noticeData{})
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		result := `#!/usr/bin/env python
+# Copyright notice
+
+print("hello")
+`
+		assertEqual(t, result, sb.String())
+	}
+
+	// A BOM ahead of the shebang must not confuse detection
+	source = "\uFEFF#!/bin/sh\necho hello\n"
+	shResult := "\uFEFF#!/bin/sh\n# Copyright notice\n\necho hello\n"
+	sb.Reset()
+	ok, err = process(strings.NewReader(source), &sb, languages[".sh"], mustTemplate("Copyright notice"), noticeData{})
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		assertEqual(t, shResult, sb.String())
+	}
+
+	// Re-running on an already-copyrighted script must replace, not duplicate, the notice
+	sb.Reset()
+	ok, err = process(strings.NewReader(shResult), &sb, languages[".sh"], mustTemplate("Copyright notice"), noticeData{})
+	assertFalse(t, ok)
+	assertNoError(t, err)
+}
+
+func Test_PHPOpener(t *testing.T) {
+	source := `<?php
+echo "hello";
+`
+	var sb strings.Builder
+	ok, err := process(strings.NewReader(source), &sb, languages[".php"], mustTemplate("Copyright notice"), noticeData{})
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		result := `<?php
+/*
+Copyright notice
+*/
+
+echo "hello";
+`
+		assertEqual(t, result, sb.String())
+	}
+}
+
+func Test_XMLProlog(t *testing.T) {
+	source := `<?xml version="1.0" encoding="UTF-8"?>
+<root>hi</root>
+`
+	var sb strings.Builder
+	ok, err := process(strings.NewReader(source), &sb, languages[".xml"], mustTemplate("Copyright notice"), noticeData{})
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		result := `<?xml version="1.0" encoding="UTF-8"?>
+<!--
+Copyright notice
+-->
+
+<root>hi</root>
+`
+		assertEqual(t, result, sb.String())
+
+		// Re-running must replace, not duplicate, the notice
+		sb.Reset()
+		ok, err = process(strings.NewReader(result), &sb, languages[".xml"], mustTemplate("Copyright notice"), noticeData{})
+		assertFalse(t, ok)
+		assertNoError(t, err)
+	}
+}
+
+func Test_HTMLDoctype(t *testing.T) {
+	source := `<!DOCTYPE html>
+<html><body>hi</body></html>
+`
+	var sb strings.Builder
+	ok, err := process(strings.NewReader(source), &sb, languages[".html"], mustTemplate("Copyright notice"), noticeData{})
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		result := `<!DOCTYPE html>
+<!--
+Copyright notice
+-->
+
+<html><body>hi</body></html>
+`
+		assertEqual(t, result, sb.String())
+	}
+}
+
+func Test_Check(t *testing.T) {
+	tmpl := mustTemplate("Copyright notice")
+	data := noticeData{}
+
+	status, err := Check(strings.NewReader("package example\n"), languages[".go"], tmpl, data)
+	if assertNoError(t, err) {
+		assertEqual(t, "missing", status)
+	}
+
+	status, err = Check(strings.NewReader("// Old copyright notice\npackage example\n"), languages[".go"], tmpl, data)
+	if assertNoError(t, err) {
+		assertEqual(t, "outdated", status)
+	}
+
+	status, err = Check(strings.NewReader("// Some unrelated remark\npackage example\n"), languages[".go"], tmpl, data)
+	if assertNoError(t, err) {
+		assertEqual(t, "malformed", status)
+	}
+
+	source := "/*\nCopyright notice\n*/\n\npackage example\n"
+	status, err = Check(strings.NewReader(source), languages[".go"], tmpl, data)
+	if assertNoError(t, err) {
+		assertEqual(t, "", status)
+	}
+
+	// An SPDX short-form notice contains no occurrence of "copyright" but is still well-formed
+	// and, once up to date, must not be reported as malformed.
+	spdxTmpl := mustTemplate("SPDX-License-Identifier: Apache-2.0")
+	spdxSource := "/*\nSPDX-License-Identifier: Apache-2.0\n*/\n\npackage example\n"
+	status, err = Check(strings.NewReader(spdxSource), languages[".go"], spdxTmpl, data)
+	if assertNoError(t, err) {
+		assertEqual(t, "", status)
+	}
+}
+
+func Test_CompileGitignoreLine(t *testing.T) {
+	if _, ok := compileGitignoreLine("", "# a comment"); !assertFalse(t, ok) {
+		t.Fatal("comment line should not compile")
+	}
+	if _, ok := compileGitignoreLine("", ""); !assertFalse(t, ok) {
+		t.Fatal("blank line should not compile")
+	}
+
+	rule, ok := compileGitignoreLine("", "*.log")
+	if assertTrue(t, ok) {
+		assertFalse(t, rule.negate)
+		assertFalse(t, rule.dirOnly)
+		assertTrue(t, gitignoreMatch([]gitignoreRule{rule}, "a.log", false))
+		assertTrue(t, gitignoreMatch([]gitignoreRule{rule}, "sub/a.log", false))
+		assertFalse(t, gitignoreMatch([]gitignoreRule{rule}, "a.txt", false))
+	}
+
+	rule, ok = compileGitignoreLine("", "/build")
+	if assertTrue(t, ok) {
+		assertTrue(t, gitignoreMatch([]gitignoreRule{rule}, "build", true))
+		assertFalse(t, gitignoreMatch([]gitignoreRule{rule}, "sub/build", true))
+	}
+
+	rule, ok = compileGitignoreLine("", "out/")
+	if assertTrue(t, ok) {
+		assertTrue(t, rule.dirOnly)
+		assertTrue(t, gitignoreMatch([]gitignoreRule{rule}, "out", true))
+		assertFalse(t, gitignoreMatch([]gitignoreRule{rule}, "out", false))
+	}
+
+	rule, ok = compileGitignoreLine("vendor", "*.tmp")
+	if assertTrue(t, ok) {
+		assertTrue(t, gitignoreMatch([]gitignoreRule{rule}, "vendor/a.tmp", false))
+		assertFalse(t, gitignoreMatch([]gitignoreRule{rule}, "a.tmp", false))
+	}
+}
+
+func Test_GitignoreNegation(t *testing.T) {
+	ignoreAll, ok := compileGitignoreLine("", "*.log")
+	assertTrue(t, ok)
+	keep, ok := compileGitignoreLine("", "!keep.log")
+	assertTrue(t, ok)
+	assertTrue(t, keep.negate)
+
+	rules := []gitignoreRule{ignoreAll, keep}
+	assertTrue(t, gitignoreMatch(rules, "a.log", false))
+	assertFalse(t, gitignoreMatch(rules, "keep.log", false))
+}
+
+func Test_LanguageFor(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	// filenames table takes priority over extension lookup.
+	lang, ok := languageFor("Dockerfile")
+	if assertTrue(t, ok) {
+		assertEqual(t, "#", lang.single)
+	}
+	lang, ok = languageFor("Makefile")
+	if assertTrue(t, ok) {
+		assertEqual(t, "#", lang.single)
+	}
+
+	// Known extension.
+	lang, ok = languageFor("main.rs")
+	if assertTrue(t, ok) {
+		assertEqual(t, "//", lang.single)
+	}
+
+	// Unrecognized extension falls through to the interpreter table.
+	_ = os.WriteFile("script", []byte("#!/usr/bin/env python\nprint('hi')\n"), 0666)
+	lang, ok = languageFor("script")
+	if assertTrue(t, ok) {
+		assertEqual(t, "#", lang.single)
+	}
+
+	// No shebang and no match anywhere.
+	_ = os.WriteFile("data.unknownext", []byte("not a script\n"), 0666)
+	_, ok = languageFor("data.unknownext")
+	assertFalse(t, ok)
+}
+
+func Test_InterpreterLanguage(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	_ = os.WriteFile("envScript", []byte("#!/usr/bin/env node\nconsole.log('hi')\n"), 0666)
+	lang, ok := interpreterLanguage("envScript")
+	if assertTrue(t, ok) {
+		assertEqual(t, "//", lang.single)
+	}
+
+	_ = os.WriteFile("directScript", []byte("#!/bin/bash\necho hi\n"), 0666)
+	lang, ok = interpreterLanguage("directScript")
+	if assertTrue(t, ok) {
+		assertEqual(t, "#", lang.single)
+	}
+
+	_ = os.WriteFile("noShebang", []byte("echo hi\n"), 0666)
+	_, ok = interpreterLanguage("noShebang")
+	assertFalse(t, ok)
+
+	_ = os.WriteFile("unknownInterpreter", []byte("#!/usr/bin/tclsh\n"), 0666)
+	_, ok = interpreterLanguage("unknownInterpreter")
+	assertFalse(t, ok)
+}
+
+func Test_ProcessDir(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	origJobs := flagJobs
+	flagJobs = 4
+	defer func() { flagJobs = origJobs }()
+
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("file%d.go", i)
+		assertNoError(t, os.WriteFile(name, []byte("package example\n"), 0666))
+	}
+
+	np := &noticeParams{tmpl: mustTemplate("Copyright notice"), year: 2024}
+	assertNoError(t, processDir(".", np, nil))
+
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("file%d.go", i)
+		b, err := os.ReadFile(name)
+		if assertNoError(t, err) {
+			assertTrue(t, bytes.Contains(b, []byte("Copyright notice")))
+		}
+	}
+}
+
+func Test_ProcessDirError(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	assertNoError(t, os.WriteFile("a.go", []byte("package example\n"), 0666))
+	assertNoError(t, os.WriteFile("b.go", []byte("package example\n"), 0666))
+
+	// A template referencing a non-existent field fails at execution time for every file,
+	// and processDir must surface that failure rather than silently swallowing it.
+	np := &noticeParams{tmpl: mustTemplate("{{.NoSuchField}}"), year: 2024}
+	assertTrue(t, processDir(".", np, nil) != nil)
+}
+
+func Test_ParseStartYearLog(t *testing.T) {
+	log := "\x012019\n" +
+		"A\toriginal.go\n" +
+		"\x012024\n" +
+		"R100\toriginal.go\trenamed.go\n"
+	addYears, renameFrom := parseStartYearLog(log)
+	assertEqual(t, 2019, addYears["original.go"])
+	assertEqual(t, "original.go", renameFrom["renamed.go"])
+	_, ok := addYears["renamed.go"]
+	assertFalse(t, ok)
+}
+
+func Test_ResolveStartYear(t *testing.T) {
+	addYears := map[string]int{"a.go": 2019}
+	renameFrom := map[string]string{"c.go": "b.go", "b.go": "a.go"}
+
+	// Follows a multi-hop rename chain back to the original add year.
+	assertEqual(t, 2019, resolveStartYear(addYears, renameFrom, "c.go", 2026))
+	// Falls back for a path git has never seen.
+	assertEqual(t, 2026, resolveStartYear(addYears, renameFrom, "untracked.go", 2026))
+	// Falls back when git history is unavailable altogether.
+	assertEqual(t, 2026, resolveStartYear(map[string]int{}, renameFrom, "a.go", 2026))
+
+	// A rename cycle must not hang the lookup.
+	cyclic := map[string]string{"a.go": "b.go", "b.go": "a.go"}
+	assertEqual(t, 2026, resolveStartYear(map[string]int{}, cyclic, "a.go", 2026))
+}
+
+func Test_StartYear(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	assertNoError(t, err)
+	defer os.Chdir(orig)
+	assertNoError(t, os.Chdir(dir))
+
+	runGit := func(args ...string) {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	runGit("init", "-q")
+	runGit("config", "user.email", "test@example.com")
+	runGit("config", "user.name", "Test")
+	assertNoError(t, os.WriteFile("original.go", []byte("package example\n"), 0666))
+	runGit("add", "original.go")
+	runGit("-c", "commit.gpgsign=false", "commit", "-q", "--date=2019-01-01T00:00:00", "-m", "add original.go")
+	runGit("mv", "original.go", "renamed.go")
+	runGit("-c", "commit.gpgsign=false", "commit", "-q", "--date=2024-01-01T00:00:00", "-m", "rename to renamed.go")
+	assertNoError(t, os.WriteFile("untracked.go", []byte("package example\n"), 0666))
+
+	out, err := exec.Command("git", startYearLogArgs...).Output()
+	assertNoError(t, err)
+	addYears, renameFrom := parseStartYearLog(string(out))
+
+	assertEqual(t, 2019, resolveStartYear(addYears, renameFrom, "renamed.go", 2026))
+	assertEqual(t, 2026, resolveStartYear(addYears, renameFrom, "untracked.go", 2026))
+}
+
+func Test_NoticeTemplate(t *testing.T) {
+	source := `package example
+`
+	var sb strings.Builder
+	tmpl := mustTemplate("Copyright {{.StartYear}}-{{.Year}} {{.Holder}}\nSPDX-License-Identifier: {{.SPDX}}\nFile: {{.File}}")
+	data := noticeData{
+		Year:      2023,
+		StartYear: 2021,
+		File:      "example.go",
+		Holder:    "Acme Corp",
+		SPDX:      "Apache-2.0",
+	}
+	ok, err := process(strings.NewReader(source), &sb, languages[".go"], tmpl, data)
+	if assertTrue(t, ok) && assertNoError(t, err) {
+		result := `/*
+Copyright 2021-2023 Acme Corp
+SPDX-License-Identifier: Apache-2.0
+File: example.go
+*/
+
+package example
+`
+		assertEqual(t, result, sb.String())
+	}
+}